@@ -0,0 +1,41 @@
+package kirin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// defaultAltSvcMaxAge is the max-age, in seconds, we advertise for the onion
+// alternative service when the operator doesn't configure one.
+const defaultAltSvcMaxAge = 86400
+
+// altSvcMiddleware wraps next so that every response advertises the onion
+// service reachable at onionAddr via the Alt-Svc header, letting Tor Browser
+// clients transparently upgrade to the onion route after their first visit.
+// It must only wrap the clearnet handler: requests that already arrived
+// through the onion HTTP/2 server use the unwrapped handler, so they never
+// see this header.
+func altSvcMiddleware(next http.Handler, cfg *config,
+	onionAddr net.Addr) http.Handler {
+
+	maxAge := cfg.Tor.AltSvcMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultAltSvcMaxAge
+	}
+
+	persist := 0
+	if cfg.Tor.AltSvcPersist {
+		persist = 1
+	}
+
+	altSvc := fmt.Sprintf(
+		`h2="%s"; ma=%d; persist=%d`, onionAddr.String(), maxAge,
+		persist,
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package kirin
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeAddr is a minimal net.Addr used to drive altSvcMiddleware in tests
+// without standing up a real onion listener.
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "tcp" }
+func (a fakeAddr) String() string  { return string(a) }
+
+func TestAltSvcMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        *config
+		wantHeader string
+	}{
+		{
+			name:       "defaults",
+			cfg:        &config{},
+			wantHeader: `h2="abc.onion:8080"; ma=86400; persist=0`,
+		},
+		{
+			name: "custom max age and persist",
+			cfg: &config{
+				Tor: TorConfig{
+					AltSvcMaxAge:  3600,
+					AltSvcPersist: true,
+				},
+			},
+			wantHeader: `h2="abc.onion:8080"; ma=3600; persist=1`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter,
+				_ *http.Request) {
+
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := altSvcMiddleware(
+				next, test.cfg, fakeAddr("abc.onion:8080"),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("Alt-Svc")
+			if got != test.wantHeader {
+				t.Fatalf("Alt-Svc header = %q, want %q", got,
+					test.wantHeader)
+			}
+		})
+	}
+}
+
+var _ net.Addr = fakeAddr("")
@@ -0,0 +1,352 @@
+package kirin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightninglabs/kirin/metrics"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// Challenger is the interface the mint needs satisfied in order to obtain a
+// fresh Lightning invoice to use as an LSAT's payment challenge. Introducing
+// it here, rather than hard-coding *LndChallenger everywhere, is what lets
+// Kirin swap in challenger backends other than a single lnd node.
+type Challenger interface {
+	// NewChallenge returns a freshly minted invoice to present to the
+	// client as an LSAT payment challenge.
+	NewChallenge() (*lnrpc.Invoice, error)
+}
+
+// AuthConfig holds the lnd connection details for an `authenticators` entry
+// in the YAML config.
+type AuthConfig struct {
+	Host        string `yaml:"host"`
+	TLSPath     string `yaml:"tlspath"`
+	MacaroonDir string `yaml:"macaroondir"`
+	Network     string `yaml:"network"`
+}
+
+// AuthenticatorConfig is a single entry of Config.Authenticators. Scheme
+// picks which ChallengerFactory builds the Challenger for this entry; the
+// embedded AuthConfig fields apply to the lnd and cluster schemes and are
+// ignored by schemes that don't need them, such as static.
+type AuthenticatorConfig struct {
+	// Scheme is the registry key for this entry's ChallengerFactory,
+	// e.g. "lnd", "cluster", or "static". Defaults to "lnd".
+	Scheme string `yaml:"scheme"`
+
+	// DSN carries scheme-specific connection info, e.g. a comma
+	// separated list of "host:port" pairs for the cluster scheme, or the
+	// fixed payment request to hand back for the static scheme.
+	DSN string `yaml:"dsn"`
+
+	AuthConfig `yaml:",inline"`
+}
+
+// ChallengerFactory constructs a Challenger from its YAML config entry and
+// the invoice request generator used to build the actual Lightning invoice.
+type ChallengerFactory func(AuthenticatorConfig,
+	InvoiceRequestGenerator) (Challenger, error)
+
+// challengerFactories is the registry of known ChallengerFactory
+// implementations, keyed by the scheme an operator sets on an
+// `authenticators` entry.
+var challengerFactories = map[string]ChallengerFactory{
+	"lnd":     newLndChallengerFactory,
+	"cluster": newClusterChallengerFactory,
+	"static":  newStaticChallengerFactory,
+}
+
+// newLndChallengerFactory adapts NewLndChallenger to the ChallengerFactory
+// signature, pinning Kirin to the single lnd node described by authCfg.
+func newLndChallengerFactory(authCfg AuthenticatorConfig,
+	genInvoiceReq InvoiceRequestGenerator) (Challenger, error) {
+
+	return NewLndChallenger(authCfg.AuthConfig, genInvoiceReq)
+}
+
+// nodeRetryCooldown is how long a cluster node stays excluded from
+// round-robin rotation after a failure before NewChallenge gives it another
+// try. Without this, a single transient blip (or a node that isn't up yet at
+// startup) would remove a node from rotation for good until Kirin is
+// restarted.
+const nodeRetryCooldown = 30 * time.Second
+
+// newClusterChallengerFactory builds a Challenger that round-robins invoice
+// generation across the comma separated "host:port" list in authCfg.DSN,
+// tracking per-node health and falling back to the next node on connection
+// loss. Nodes are brought up optimistically: a host that can't be reached at
+// startup is added in an unhealthy state rather than failing the whole
+// factory, so one bad node in the list can't take down the entire paywall.
+// The factory only errors out if every node fails to connect.
+func newClusterChallengerFactory(authCfg AuthenticatorConfig,
+	genInvoiceReq InvoiceRequestGenerator) (Challenger, error) {
+
+	hosts := strings.Split(authCfg.DSN, ",")
+	if len(hosts) == 0 || hosts[0] == "" {
+		return nil, fmt.Errorf("cluster authenticator requires at " +
+			"least one host in its dsn")
+	}
+
+	nodes := make([]*clusterNode, len(hosts))
+	numHealthy := 0
+	for i, host := range hosts {
+		nodeCfg := authCfg.AuthConfig
+		nodeCfg.Host = strings.TrimSpace(host)
+
+		node := &clusterNode{
+			host: nodeCfg.Host,
+			connect: func() (Challenger, error) {
+				return NewLndChallenger(nodeCfg, genInvoiceReq)
+			},
+		}
+
+		challenger, err := node.connect()
+		if err != nil {
+			log.Errorf("Unable to connect to lnd node %v, "+
+				"marking unhealthy: %v", nodeCfg.Host, err)
+			node.markUnhealthy()
+		} else {
+			node.challenger = challenger
+			node.healthy = true
+			numHealthy++
+		}
+
+		metrics.SetLndConnStatus(nodeCfg.Host, node.healthy)
+		nodes[i] = node
+	}
+
+	if numHealthy == 0 {
+		return nil, fmt.Errorf("unable to connect to any of the %d "+
+			"cluster authenticator nodes", len(nodes))
+	}
+
+	return &clusterChallenger{nodes: nodes}, nil
+}
+
+// newStaticChallengerFactory builds a Challenger that always returns the
+// same, pre-configured invoice. It exists for local development and tests,
+// where standing up a real lnd node isn't practical.
+func newStaticChallengerFactory(authCfg AuthenticatorConfig,
+	_ InvoiceRequestGenerator) (Challenger, error) {
+
+	if authCfg.DSN == "" {
+		return nil, fmt.Errorf("static authenticator requires a " +
+			"payment request in its dsn")
+	}
+
+	return &staticChallenger{paymentRequest: authCfg.DSN}, nil
+}
+
+// staticChallenger is a Challenger that always hands back the same,
+// pre-configured invoice.
+type staticChallenger struct {
+	paymentRequest string
+}
+
+// NewChallenge returns the pre-configured invoice.
+func (s *staticChallenger) NewChallenge() (*lnrpc.Invoice, error) {
+	return &lnrpc.Invoice{PaymentRequest: s.paymentRequest}, nil
+}
+
+// clusterNode is a single lnd endpoint participating in a clusterChallenger,
+// along with the health flag used to skip it after a failure. connect is
+// kept around so a node that failed to connect, either at construction or
+// during later use, can be retried without restarting Kirin.
+type clusterNode struct {
+	host    string
+	connect func() (Challenger, error)
+
+	mu             sync.Mutex
+	challenger     Challenger
+	healthy        bool
+	unhealthySince time.Time
+}
+
+// markUnhealthy flags the node as unhealthy and records when that happened,
+// so getChallenger knows when nodeRetryCooldown has elapsed.
+func (n *clusterNode) markUnhealthy() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.healthy = false
+	n.unhealthySince = time.Now()
+}
+
+// getChallenger returns the node's Challenger if it's currently healthy. If
+// the node is unhealthy but has been in cooldown for at least
+// nodeRetryCooldown, it retries connecting before giving up, giving a node
+// that's come back up a chance to rejoin rotation on its own.
+func (n *clusterNode) getChallenger() (Challenger, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.healthy {
+		return n.challenger, nil
+	}
+
+	if time.Since(n.unhealthySince) < nodeRetryCooldown {
+		return nil, fmt.Errorf("node %v is unhealthy", n.host)
+	}
+
+	challenger, err := n.connect()
+	if err != nil {
+		n.unhealthySince = time.Now()
+		return nil, fmt.Errorf("retrying node %v still failing: %v",
+			n.host, err)
+	}
+
+	log.Infof("Challenger node %v has recovered, marking healthy",
+		n.host)
+	n.challenger = challenger
+	n.healthy = true
+	return n.challenger, nil
+}
+
+// clusterChallenger round-robins invoice generation across a set of
+// Challengers, tracking per-node health and falling back to the next node on
+// connection loss so a single node outage doesn't take down the paywall.
+// Unhealthy nodes are retried on a cooldown so a transient failure doesn't
+// remove a node from rotation permanently.
+type clusterChallenger struct {
+	nodes []*clusterNode
+	next  uint32
+}
+
+// NewChallenge tries each node starting at the next one in round-robin
+// order, skipping any that are unhealthy and still inside their retry
+// cooldown, until one successfully returns an invoice or every node has been
+// tried.
+func (c *clusterChallenger) NewChallenge() (*lnrpc.Invoice, error) {
+	start := atomic.AddUint32(&c.next, 1) - 1
+
+	var lastErr error
+	for i := 0; i < len(c.nodes); i++ {
+		node := c.nodes[(int(start)+i)%len(c.nodes)]
+
+		challenger, err := node.getChallenger()
+		if err != nil {
+			metrics.SetLndConnStatus(node.host, false)
+			lastErr = err
+			continue
+		}
+
+		reqStart := time.Now()
+		invoice, err := challenger.NewChallenge()
+		if err != nil {
+			log.Errorf("Challenger node %v failed, marking "+
+				"unhealthy: %v", node.host, err)
+			node.markUnhealthy()
+			metrics.SetLndConnStatus(node.host, false)
+			lastErr = err
+			continue
+		}
+
+		metrics.SetLndConnStatus(node.host, true)
+		metrics.ObserveInvoiceLatency(time.Since(reqStart))
+		return invoice, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no healthy challenger nodes available")
+	}
+	return nil, fmt.Errorf("all challenger nodes failed, last error: %v",
+		lastErr)
+}
+
+// instrumentedChallenger wraps a Challenger to record invoice generation
+// latency and lnd connection health. clusterChallenger already records these
+// itself per node, so buildChallenger only needs this wrapper for the common
+// single-authenticator case, which would otherwise never surface in the
+// invoice_generation_latency_seconds or lnd_connection_up metrics.
+type instrumentedChallenger struct {
+	label      string
+	challenger Challenger
+}
+
+// NewChallenge generates a new invoice via the wrapped Challenger, recording
+// its latency and the resulting connection health under i.label.
+func (i *instrumentedChallenger) NewChallenge() (*lnrpc.Invoice, error) {
+	start := time.Now()
+	invoice, err := i.challenger.NewChallenge()
+	metrics.SetLndConnStatus(i.label, err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ObserveInvoiceLatency(time.Since(start))
+	return invoice, nil
+}
+
+// buildChallenger inspects cfg.Authenticators and constructs the Challenger
+// to thread into mint.Config. A single entry is wrapped in
+// instrumentedChallenger so it still reports latency and connection health;
+// more than one is combined into a clusterChallenger, which tracks that
+// itself per node, so a failure in one authenticator doesn't take down the
+// whole paywall, regardless of each entry's scheme.
+func buildChallenger(cfg *config,
+	genInvoiceReq InvoiceRequestGenerator) (Challenger, error) {
+
+	if len(cfg.Authenticators) == 0 {
+		return nil, fmt.Errorf("at least one authenticator must be " +
+			"configured")
+	}
+
+	nodes := make([]*clusterNode, 0, len(cfg.Authenticators))
+	for _, authCfg := range cfg.Authenticators {
+		authCfg := authCfg
+		scheme := authCfg.Scheme
+		if scheme == "" {
+			scheme = "lnd"
+		}
+
+		factory, ok := challengerFactories[scheme]
+		if !ok {
+			return nil, fmt.Errorf("unknown authenticator scheme "+
+				"%q", scheme)
+		}
+
+		challenger, err := factory(authCfg, genInvoiceReq)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create %v "+
+				"challenger: %v", scheme, err)
+		}
+
+		if len(cfg.Authenticators) == 1 {
+			return &instrumentedChallenger{
+				label:      scheme,
+				challenger: challenger,
+			}, nil
+		}
+		nodes = append(nodes, &clusterNode{
+			host:       authenticatorIdentifier(authCfg, scheme),
+			challenger: challenger,
+			healthy:    true,
+			connect: func() (Challenger, error) {
+				return factory(authCfg, genInvoiceReq)
+			},
+		})
+	}
+
+	return &clusterChallenger{nodes: nodes}, nil
+}
+
+// authenticatorIdentifier picks the string used to identify an authenticator
+// entry in logs and per-node metrics. It prefers the entry's lnd host, then
+// its DSN, falling back to the scheme name only when neither is set, so
+// e.g. two "lnd" scheme entries pointing at different nodes don't collide
+// under the same label.
+func authenticatorIdentifier(authCfg AuthenticatorConfig, scheme string) string {
+	switch {
+	case authCfg.Host != "":
+		return authCfg.Host
+	case authCfg.DSN != "":
+		return authCfg.DSN
+	default:
+		return scheme
+	}
+}
@@ -0,0 +1,231 @@
+package kirin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// fakeChallenger is a Challenger whose behavior is controlled by a test, so
+// clusterChallenger's round-robin, failover and recovery logic can be
+// exercised without a real lnd node.
+type fakeChallenger struct {
+	invoice *lnrpc.Invoice
+	err     error
+	calls   int
+}
+
+func (f *fakeChallenger) NewChallenge() (*lnrpc.Invoice, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.invoice, nil
+}
+
+func newTestNode(host string, challenger *fakeChallenger) *clusterNode {
+	return &clusterNode{
+		host:       host,
+		healthy:    true,
+		challenger: challenger,
+		connect: func() (Challenger, error) {
+			return challenger, nil
+		},
+	}
+}
+
+func TestClusterChallengerFailover(t *testing.T) {
+	good := &fakeChallenger{invoice: &lnrpc.Invoice{PaymentRequest: "good"}}
+	bad := &fakeChallenger{err: fmt.Errorf("connection refused")}
+
+	c := &clusterChallenger{
+		nodes: []*clusterNode{
+			newTestNode("bad", bad),
+			newTestNode("good", good),
+		},
+	}
+
+	invoice, err := c.NewChallenge()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if invoice.PaymentRequest != "good" {
+		t.Fatalf("got payment request %q, want %q",
+			invoice.PaymentRequest, "good")
+	}
+
+	if c.nodes[0].healthy {
+		t.Fatalf("expected bad node to be marked unhealthy")
+	}
+}
+
+func TestClusterChallengerAllNodesFail(t *testing.T) {
+	bad1 := &fakeChallenger{err: fmt.Errorf("node 1 down")}
+	bad2 := &fakeChallenger{err: fmt.Errorf("node 2 down")}
+
+	c := &clusterChallenger{
+		nodes: []*clusterNode{
+			newTestNode("bad1", bad1),
+			newTestNode("bad2", bad2),
+		},
+	}
+
+	if _, err := c.NewChallenge(); err == nil {
+		t.Fatal("expected an error when every node fails")
+	}
+	for _, node := range c.nodes {
+		if node.healthy {
+			t.Fatalf("expected node %v to be marked unhealthy",
+				node.host)
+		}
+	}
+}
+
+func TestNewClusterChallengerFactoryRejectsEmptyDSN(t *testing.T) {
+	_, err := newClusterChallengerFactory(
+		AuthenticatorConfig{Scheme: "cluster"}, nil,
+	)
+	if err == nil {
+		t.Fatal("expected an error for a cluster authenticator with " +
+			"no hosts in its dsn")
+	}
+}
+
+func TestBuildChallengerValidation(t *testing.T) {
+	if _, err := buildChallenger(&config{}, nil); err == nil {
+		t.Fatal("expected an error with zero authenticators configured")
+	}
+
+	cfg := &config{
+		Authenticators: []AuthenticatorConfig{
+			{Scheme: "bogus"},
+		},
+	}
+	if _, err := buildChallenger(cfg, nil); err == nil {
+		t.Fatal("expected an error for an unknown authenticator scheme")
+	}
+}
+
+// TestBuildChallengerStatic exercises buildChallenger's scheme/DSN parsing
+// using the static scheme, which (like in tests generally) doesn't require
+// standing up a real lnd node to connect to.
+func TestBuildChallengerStatic(t *testing.T) {
+	cfg := &config{
+		Authenticators: []AuthenticatorConfig{
+			{Scheme: "static", DSN: "lnbc1single"},
+		},
+	}
+
+	challenger, err := buildChallenger(cfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	invoice, err := challenger.NewChallenge()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if invoice.PaymentRequest != "lnbc1single" {
+		t.Fatalf("got payment request %q, want %q",
+			invoice.PaymentRequest, "lnbc1single")
+	}
+}
+
+// TestBuildChallengerMultipleStaticNodes covers the multi-authenticator
+// fallback path, asserting each clusterNode is identified by its DSN rather
+// than the shared "static" scheme name, which is what let same-scheme nodes
+// collide under one label before this was fixed.
+func TestBuildChallengerMultipleStaticNodes(t *testing.T) {
+	cfg := &config{
+		Authenticators: []AuthenticatorConfig{
+			{Scheme: "static", DSN: "lnbc1first"},
+			{Scheme: "static", DSN: "lnbc1second"},
+		},
+	}
+
+	challenger, err := buildChallenger(cfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cluster, ok := challenger.(*clusterChallenger)
+	if !ok {
+		t.Fatalf("expected a *clusterChallenger, got %T", challenger)
+	}
+	if len(cluster.nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(cluster.nodes))
+	}
+	if cluster.nodes[0].host == cluster.nodes[1].host {
+		t.Fatalf("expected distinct node identifiers, both got %q",
+			cluster.nodes[0].host)
+	}
+}
+
+func TestAuthenticatorIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		authCfg AuthenticatorConfig
+		scheme  string
+		want    string
+	}{
+		{
+			name:    "prefers host",
+			authCfg: AuthenticatorConfig{AuthConfig: AuthConfig{Host: "lnd1:10009"}},
+			scheme:  "lnd",
+			want:    "lnd1:10009",
+		},
+		{
+			name:    "falls back to dsn",
+			authCfg: AuthenticatorConfig{DSN: "lnbc1xyz"},
+			scheme:  "static",
+			want:    "lnbc1xyz",
+		},
+		{
+			name:    "falls back to scheme",
+			authCfg: AuthenticatorConfig{},
+			scheme:  "static",
+			want:    "static",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := authenticatorIdentifier(test.authCfg, test.scheme)
+			if got != test.want {
+				t.Fatalf("authenticatorIdentifier() = %q, want %q",
+					got, test.want)
+			}
+		})
+	}
+}
+
+func TestClusterNodeRecoversAfterCooldown(t *testing.T) {
+	recovered := &fakeChallenger{invoice: &lnrpc.Invoice{
+		PaymentRequest: "recovered",
+	}}
+
+	node := newTestNode("flaky", recovered)
+	node.markUnhealthy()
+
+	// Still inside the cooldown window, so the node should stay
+	// unhealthy and connect should not be retried.
+	if _, err := node.getChallenger(); err == nil {
+		t.Fatal("expected node still in cooldown to return an error")
+	}
+
+	// Simulate the cooldown having elapsed.
+	node.unhealthySince = time.Now().Add(-2 * nodeRetryCooldown)
+
+	challenger, err := node.getChallenger()
+	if err != nil {
+		t.Fatalf("expected node past cooldown to recover: %v", err)
+	}
+	if challenger != Challenger(recovered) {
+		t.Fatal("expected recovered node to return its challenger")
+	}
+	if !node.healthy {
+		t.Fatal("expected node to be marked healthy again")
+	}
+}
@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/lightninglabs/kirin/auth"
+	"github.com/lightninglabs/kirin/metrics"
 	"github.com/lightninglabs/kirin/mint"
 	"github.com/lightninglabs/kirin/proxy"
 	"github.com/lightningnetwork/lnd/build"
@@ -32,6 +37,14 @@ const (
 	// etcdKeyDelimeter is the delimeter we'll use for all etcd keys to
 	// represent a path-like structure.
 	etcdKeyDelimeter = "/"
+
+	// unixSockPrefix is the URI scheme operators can use in ListenAddr to
+	// explicitly request a Unix domain socket listener.
+	unixSockPrefix = "unix://"
+
+	// defaultSockFileMode is the file mode applied to a freshly created
+	// Unix domain socket when the config doesn't specify one.
+	defaultSockFileMode = 0660
 )
 
 // Main is the true entrypoint of Kirin.
@@ -58,13 +71,24 @@ func start() error {
 		return fmt.Errorf("unable to set up logging: %v", err)
 	}
 
+	// If the operator configured a root CA bundle for etcd, start a
+	// watcher that keeps our trust anchor up to date as it's rotated on
+	// the etcd side, without requiring a restart or dropping our watch
+	// on topLevelKey.
+	etcdTLSConfig, err := buildEtcdTLSConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to set up etcd TLS config: %v", err)
+	}
+
 	// Initialize our etcd client.
 	etcdClient, err := clientv3.New(clientv3.Config{
 		Endpoints:   []string{cfg.Etcd.Host},
 		DialTimeout: 5 * time.Second,
 		Username:    cfg.Etcd.User,
 		Password:    cfg.Etcd.Password,
+		TLS:         etcdTLSConfig,
 	})
+	metrics.SetEtcdConnStatus(err == nil)
 	if err != nil {
 		return fmt.Errorf("unable to connect to etcd: %v", err)
 	}
@@ -80,10 +104,105 @@ func start() error {
 	if err != nil {
 		return err
 	}
-	handler := http.HandlerFunc(servicesProxy.ServeHTTP)
+	// Instrument every proxied request with Prometheus metrics. The label
+	// is the service name stashed on the request context via
+	// WithServiceName if one is present, otherwise the raw Host header.
+	// The label is read before the request reaches servicesProxy, and
+	// nothing on this path calls WithServiceName, so requests are labeled
+	// by their Host header rather than by the service matched in
+	// proxy.New.
+	handler := metrics.Middleware(
+		http.HandlerFunc(servicesProxy.ServeHTTP),
+		func(r *http.Request) string {
+			if name, ok := ServiceNameFromContext(r.Context()); ok {
+				return name
+			}
+			return r.Host
+		},
+	)
+
+	// If the operator configured a separate admin listener, expose our
+	// Prometheus metrics there rather than on the public-facing proxy
+	// listener(s). It runs in its own goroutine alongside the listener(s)
+	// set up below and is closed when start returns.
+	if cfg.MetricsAddr != "" {
+		metricsHandler := metrics.Handler()
+		if cfg.MetricsAuth.User != "" {
+			metricsHandler = metrics.WithBasicAuth(
+				metricsHandler, cfg.MetricsAuth.User,
+				cfg.MetricsAuth.Password,
+			)
+		}
+
+		metricsServer := &http.Server{
+			Addr:    cfg.MetricsAddr,
+			Handler: metricsHandler,
+		}
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Errorf("metrics listener: %v", err)
+			}
+		}()
+		defer metricsServer.Close()
+
+		log.Infof("Exposing Prometheus metrics on %v", cfg.MetricsAddr)
+	}
+
+	// If the operator pointed us at a filesystem path (either a bare
+	// path or one prefixed with the unix:// scheme), they want Kirin to
+	// listen on a Unix domain socket instead of a TCP port. This is
+	// useful for operators that already run nginx/Caddy on the same
+	// host to terminate TLS and do ACME, and don't want Kirin exposing
+	// an extra TCP port of its own.
+	if sockPath, ok := unixSocketPath(cfg.ListenAddr); ok {
+		return listenAndServeUnix(sockPath, cfg, handler, etcdClient)
+	}
+
+	errChan := make(chan error)
+
+	// If we need to listen over Tor as well, we'll set up the onion
+	// services now, before the clearnet handler below, since we need the
+	// onion address to advertise via Alt-Svc. We're not able to use TLS
+	// for onion services since they can't be verified, so we'll spin up
+	// an additional HTTP/2 server _without_ TLS that is not exposed to
+	// the outside world. This server will only be reached through the
+	// onion services, which already provide encryption, so running this
+	// additional HTTP server should be relatively safe.
+	var onionAddr net.Addr
+	if cfg.Tor.V2 || cfg.Tor.V3 {
+		torController, addr, err := initTorListener(cfg, etcdClient)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = torController.Stop()
+		}()
+		onionAddr = addr
+
+		torServer := &http.Server{
+			Addr:    fmt.Sprintf("localhost:%d", cfg.Tor.ListenPort),
+			Handler: h2c.NewHandler(handler, &http2.Server{}),
+		}
+		go func() {
+			errChan <- torServer.ListenAndServe()
+		}()
+		defer torServer.Close()
+	}
+
+	// If we have an onion service configured, wrap the clearnet handler
+	// so Tor Browser clients that hit us over clearnet first learn they
+	// can transparently upgrade to the onion route. Requests that already
+	// arrived through the onion HTTP/2 server above use the unwrapped
+	// handler, so they never see this header.
+	clearnetHandler := handler
+	if onionAddr != nil {
+		clearnetHandler = altSvcMiddleware(handler, cfg, onionAddr)
+	}
+
 	httpsServer := &http.Server{
 		Addr:    cfg.ListenAddr,
-		Handler: handler,
+		Handler: clearnetHandler,
 	}
 
 	// Create TLS certificates.
@@ -118,6 +237,7 @@ func start() error {
 		}()
 		httpsServer.TLSConfig = &tls.Config{
 			GetCertificate: manager.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
 		}
 
 	// If we're not using autocert, we want to create self-signed TLS certs
@@ -138,6 +258,40 @@ func start() error {
 			}
 			log.Infof("Done generating TLS certificates")
 		}
+
+		// Rather than reading the cert/key once and holding onto it
+		// for the lifetime of the process, watch both files so an
+		// operator can rotate them (e.g. via a cert-manager renewal)
+		// without having to restart Kirin.
+		reloadInterval := cfg.TLS.ReloadInterval
+		if reloadInterval <= 0 {
+			reloadInterval = defaultCertReloadInterval
+		}
+		reloader, err := newCertReloader(
+			tlsCertFile, tlsKeyFile, reloadInterval,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to watch TLS cert/key: %v", err)
+		}
+		httpsServer.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+		tlsKeyFile, tlsCertFile = "", ""
+	}
+
+	// Explicitly configure HTTP/2 support on the TLS listener. Without
+	// this, clients negotiating "h2" via ALPN would fall back to
+	// HTTP/1.1.
+	//
+	// NOTE: this only gets Kirin as far as accepting HTTP/2 connections.
+	// It does not make Kirin gRPC-aware: detecting a gRPC content-type
+	// and proxying it to a cleartext upstream with prior knowledge is
+	// proxy-routing logic that doesn't exist anywhere in this codebase
+	// yet. Don't read the presence of this block as "Kirin proxies
+	// gRPC" -- it doesn't, until that routing is added to proxy.New.
+	if err := http2.ConfigureServer(httpsServer, &http2.Server{}); err != nil {
+		return fmt.Errorf("unable to configure HTTP/2: %v", err)
 	}
 
 	// The ListenAndServeTLS below will block until shut down or an error
@@ -148,38 +302,96 @@ func start() error {
 	// Finally start the server.
 	log.Infof("Starting the server, listening on %s.", cfg.ListenAddr)
 
-	errChan := make(chan error)
 	go func() {
 		errChan <- httpsServer.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
 	}()
 
-	// If we need to listen over Tor as well, we'll set up the onion
-	// services now. We're not able to use TLS for onion services since they
-	// can't be verified, so we'll spin up an additional HTTP/2 server
-	// _without_ TLS that is not exposed to the outside world. This server
-	// will only be reached through the onion services, which already
-	// provide encryption, so running this additional HTTP server should be
-	// relatively safe.
-	if cfg.Tor.V2 || cfg.Tor.V3 {
-		torController, err := initTorListener(cfg, etcdClient)
-		if err != nil {
-			return err
+	return <-errChan
+}
+
+// unixSocketPath inspects listenAddr and, if it refers to a Unix domain
+// socket (either via the unix:// scheme or a bare filesystem path), returns
+// the socket path with the scheme stripped.
+func unixSocketPath(listenAddr string) (string, bool) {
+	switch {
+	case strings.HasPrefix(listenAddr, unixSockPrefix):
+		return strings.TrimPrefix(listenAddr, unixSockPrefix), true
+
+	case strings.HasPrefix(listenAddr, "/"):
+		return listenAddr, true
+
+	default:
+		return "", false
+	}
+}
+
+// listenAndServeUnix serves the proxy over a Unix domain socket using
+// cleartext HTTP/2, instead of the usual TLS-terminated TCP listener. This
+// lets operators front Kirin with a reverse proxy such as nginx or Caddy
+// that already terminates TLS and handles ACME on the same host, without
+// exposing an extra TCP port.
+func listenAndServeUnix(sockPath string, cfg *config, handler http.Handler,
+	etcdClient *clientv3.Client) error {
+
+	// Remove any stale socket file left behind by a previous, unclean
+	// shutdown before we try to bind to it.
+	if fileExists(sockPath) {
+		if err := os.Remove(sockPath); err != nil {
+			return fmt.Errorf("unable to remove stale socket "+
+				"%v: %v", sockPath, err)
 		}
-		defer func() {
-			_ = torController.Stop()
-		}()
+	}
 
-		httpServer := &http.Server{
-			Addr:    fmt.Sprintf("localhost:%d", cfg.Tor.ListenPort),
-			Handler: h2c.NewHandler(handler, &http2.Server{}),
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return fmt.Errorf("unable to listen on socket %v: %v",
+			sockPath, err)
+	}
+
+	sockFileMode := os.FileMode(defaultSockFileMode)
+	if cfg.SocketFileMode != 0 {
+		sockFileMode = os.FileMode(cfg.SocketFileMode)
+	}
+	if err := os.Chmod(sockPath, sockFileMode); err != nil {
+		return fmt.Errorf("unable to set file mode on socket %v: %v",
+			sockPath, err)
+	}
+
+	// If the operator wants the socket owned by a particular user (e.g.
+	// the nginx/Caddy worker's account), look it up and chown the file
+	// accordingly. Left unset, the socket keeps the uid/gid of the
+	// process that created it, same as before this option existed.
+	if cfg.SocketFileOwner != "" {
+		owner, err := user.Lookup(cfg.SocketFileOwner)
+		if err != nil {
+			return fmt.Errorf("unable to look up socket owner "+
+				"%v: %v", cfg.SocketFileOwner, err)
+		}
+		uid, err := strconv.Atoi(owner.Uid)
+		if err != nil {
+			return fmt.Errorf("invalid uid %v for socket owner "+
+				"%v: %v", owner.Uid, cfg.SocketFileOwner, err)
+		}
+		gid, err := strconv.Atoi(owner.Gid)
+		if err != nil {
+			return fmt.Errorf("invalid gid %v for socket owner "+
+				"%v: %v", owner.Gid, cfg.SocketFileOwner, err)
+		}
+		if err := os.Chown(sockPath, uid, gid); err != nil {
+			return fmt.Errorf("unable to set ownership on socket "+
+				"%v: %v", sockPath, err)
 		}
-		go func() {
-			errChan <- httpServer.ListenAndServe()
-		}()
-		defer httpServer.Close()
 	}
 
-	return <-errChan
+	socketServer := &http.Server{
+		Handler: h2c.NewHandler(handler, &http2.Server{}),
+	}
+	defer cleanup(etcdClient, socketServer)
+
+	log.Infof("Starting the server, listening on unix socket %v with "+
+		"mode %v.", sockPath, sockFileMode)
+
+	return socketServer.Serve(listener)
 }
 
 // fileExists reports whether the named file or directory exists.
@@ -232,9 +444,13 @@ func setupLogging(cfg *config) error {
 }
 
 // initTorListener initiates a Tor controller instance with the Tor server
-// specified in the config. Onion services will be created over which the proxy
-// can be reached at.
-func initTorListener(cfg *config, etcd *clientv3.Client) (*tor.Controller, error) {
+// specified in the config. Onion services will be created over which the
+// proxy can be reached at. It returns the address of the last onion service
+// created (preferring v3 over v2, since that's the one worth advertising via
+// Alt-Svc) so the caller can thread it into the clearnet Alt-Svc middleware.
+func initTorListener(cfg *config, etcd *clientv3.Client) (*tor.Controller,
+	net.Addr, error) {
+
 	// Establish a controller connection with the backing Tor server and
 	// proceed to create the requested onion services.
 	onionCfg := tor.AddOnionConfig{
@@ -244,43 +460,93 @@ func initTorListener(cfg *config, etcd *clientv3.Client) (*tor.Controller, error
 	}
 	torController := tor.NewController(cfg.Tor.Control, "", "")
 	if err := torController.Start(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	var onionAddr net.Addr
 	if cfg.Tor.V2 {
 		onionCfg.Type = tor.V2
 		addr, err := torController.AddOnion(onionCfg)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		log.Infof("Listening over Tor on %v", addr)
+		onionAddr = addr
 	}
 
 	if cfg.Tor.V3 {
 		onionCfg.Type = tor.V3
 		addr, err := torController.AddOnion(onionCfg)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		log.Infof("Listening over Tor on %v", addr)
+		onionAddr = addr
+	}
+
+	return torController, onionAddr, nil
+}
+
+// buildEtcdTLSConfig assembles a *tls.Config for dialing etcd whose root CA
+// pool (and, if configured, client certificate) stay current as they're
+// rotated on disk, so a trust anchor rotation on the etcd side doesn't
+// require Kirin to redial or drop its watch on topLevelKey. It returns nil
+// if no etcd CA file is configured, in which case a configured etcd client
+// cert/key is not used either.
+func buildEtcdTLSConfig(cfg *config) (*tls.Config, error) {
+	if cfg.Etcd.CAFile == "" {
+		return nil, nil
+	}
+
+	reloadInterval := cfg.Etcd.CAReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultCertReloadInterval
+	}
+
+	etcdHost := cfg.Etcd.Host
+	if host, _, err := net.SplitHostPort(etcdHost); err == nil {
+		etcdHost = host
+	}
+
+	caWatcher, err := newCAPoolWatcher(
+		cfg.Etcd.CAFile, etcdHost, reloadInterval,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch etcd CA file: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: caWatcher.VerifyPeerCertificate,
+	}
+
+	if cfg.Etcd.CertFile != "" && cfg.Etcd.KeyFile != "" {
+		certReloader, err := newCertReloader(
+			cfg.Etcd.CertFile, cfg.Etcd.KeyFile, reloadInterval,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to watch etcd client "+
+				"cert/key: %v", err)
+		}
+		tlsConfig.GetClientCertificate = certReloader.GetClientCertificate
 	}
 
-	return torController, nil
+	return tlsConfig, nil
 }
 
 // createProxy creates the proxy with all the services it needs.
 func createProxy(cfg *config, genInvoiceReq InvoiceRequestGenerator,
 	etcdClient *clientv3.Client) (*proxy.Proxy, error) {
 
-	challenger, err := NewLndChallenger(cfg.Authenticator, genInvoiceReq)
+	challenger, err := buildChallenger(cfg, genInvoiceReq)
 	if err != nil {
 		return nil, err
 	}
 	minter := mint.New(&mint.Config{
 		Challenger:     challenger,
-		Secrets:        newSecretStore(etcdClient),
+		Secrets:        newInstrumentedSecretStore(newSecretStore(etcdClient)),
 		ServiceLimiter: newStaticServiceLimiter(cfg.Services),
 	})
 	authenticator := auth.NewLsatAuthenticator(minter)
@@ -0,0 +1,54 @@
+package kirin
+
+import "testing"
+
+func TestUnixSocketPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		listenAddr string
+		wantPath   string
+		wantIsUnix bool
+	}{
+		{
+			name:       "unix scheme prefix",
+			listenAddr: "unix:///var/run/kirin.sock",
+			wantPath:   "/var/run/kirin.sock",
+			wantIsUnix: true,
+		},
+		{
+			name:       "bare absolute path",
+			listenAddr: "/var/run/kirin.sock",
+			wantPath:   "/var/run/kirin.sock",
+			wantIsUnix: true,
+		},
+		{
+			name:       "tcp host:port",
+			listenAddr: "0.0.0.0:8081",
+			wantPath:   "",
+			wantIsUnix: false,
+		},
+		{
+			name:       "bare host without scheme or leading slash",
+			listenAddr: "localhost:8081",
+			wantPath:   "",
+			wantIsUnix: false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			gotPath, gotIsUnix := unixSocketPath(test.listenAddr)
+			if gotIsUnix != test.wantIsUnix {
+				t.Fatalf("unixSocketPath(%q) isUnix = %v, "+
+					"want %v", test.listenAddr, gotIsUnix,
+					test.wantIsUnix)
+			}
+			if gotPath != test.wantPath {
+				t.Fatalf("unixSocketPath(%q) path = %q, "+
+					"want %q", test.listenAddr, gotPath,
+					test.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Package metrics registers the Prometheus collectors Kirin exposes for
+// operators running it as production paywall infrastructure, and provides
+// the middleware and HTTP handler needed to record and serve them.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	issuedLSATs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kirin",
+		Name:      "lsats_issued_total",
+		Help:      "Total number of LSATs issued by the mint.",
+	})
+
+	invoiceLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kirin",
+		Name:      "invoice_generation_latency_seconds",
+		Help: "Latency of generating a new Lightning invoice for an " +
+			"LSAT challenge.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kirin",
+		Name:      "requests_total",
+		Help:      "Total number of proxied requests, by service and response code.",
+	}, []string{"service", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kirin",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of proxied requests, by service.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service"})
+
+	secretStoreOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kirin",
+		Name:      "mint_secret_store_ops_total",
+		Help: "Total number of operations performed against the " +
+			"mint's secret store, by op and result.",
+	}, []string{"op", "result"})
+
+	etcdConnUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kirin",
+		Name:      "etcd_connection_up",
+		Help:      "Whether the connection to etcd is currently up (1) or not (0).",
+	})
+
+	lndConnUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kirin",
+		Name:      "lnd_connection_up",
+		Help: "Whether the connection to a given lnd node is " +
+			"currently up (1) or not (0).",
+	}, []string{"node"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		issuedLSATs, invoiceLatency, requestsTotal, requestDuration,
+		secretStoreOps, etcdConnUp, lndConnUp,
+	)
+}
+
+// IssuedLSAT records that the mint issued a fresh LSAT.
+func IssuedLSAT() {
+	issuedLSATs.Inc()
+}
+
+// ObserveInvoiceLatency records how long it took to generate a new invoice
+// for an LSAT challenge.
+func ObserveInvoiceLatency(d time.Duration) {
+	invoiceLatency.Observe(d.Seconds())
+}
+
+// ObserveSecretStoreOp records the outcome of a mint secret store operation.
+func ObserveSecretStoreOp(op string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	secretStoreOps.WithLabelValues(op, result).Inc()
+}
+
+// SetEtcdConnStatus records whether the connection to etcd is currently up.
+func SetEtcdConnStatus(up bool) {
+	etcdConnUp.Set(boolToFloat(up))
+}
+
+// SetLndConnStatus records whether the connection to the named lnd node is
+// currently up.
+func SetLndConnStatus(node string, up bool) {
+	lndConnUp.WithLabelValues(node).Set(boolToFloat(up))
+}
+
+func boolToFloat(up bool) float64 {
+	if up {
+		return 1
+	}
+	return 0
+}
+
+// Middleware wraps next, recording per-service request counts and
+// durations. serviceLabel is called with the incoming request before next
+// runs and returns the service label to record the request under.
+func Middleware(next http.Handler,
+	serviceLabel func(*http.Request) string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service := serviceLabel(r)
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		requestDuration.WithLabelValues(service).Observe(
+			time.Since(start).Seconds(),
+		)
+		requestsTotal.WithLabelValues(
+			service, strconv.Itoa(rec.status),
+		).Inc()
+	})
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// written by the handler it wraps.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records status before delegating to the wrapped writer.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Handler returns the http.Handler that serves the registered collectors in
+// the Prometheus exposition format, meant to be mounted on the admin
+// listener rather than the public-facing proxy listener.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// WithBasicAuth wraps next with HTTP basic auth, for operators who want the
+// metrics admin listener reachable without relying solely on network
+// policy.
+func WithBasicAuth(next http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPassword, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare(
+			[]byte(gotUser), []byte(user),
+		) == 1
+		passMatch := subtle.ConstantTimeCompare(
+			[]byte(gotPassword), []byte(password),
+		) == 1
+		if !ok || !userMatch || !passMatch {
+			w.Header().Set(
+				"WWW-Authenticate", `Basic realm="kirin metrics"`,
+			)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package kirin
+
+import (
+	"crypto/sha256"
+
+	"github.com/lightninglabs/kirin/metrics"
+	"github.com/lightninglabs/kirin/mint"
+)
+
+// instrumentedSecretStore wraps a mint.SecretStore to record, via the
+// metrics package, every secret store operation the mint performs and every
+// LSAT issued as a result of a successful NewSecret call.
+type instrumentedSecretStore struct {
+	mint.SecretStore
+}
+
+// newInstrumentedSecretStore wraps store so its operations show up in the
+// mint_secret_store_ops_total and lsats_issued_total metrics.
+func newInstrumentedSecretStore(store mint.SecretStore) mint.SecretStore {
+	return &instrumentedSecretStore{SecretStore: store}
+}
+
+// NewSecret generates a new secret via the wrapped store, recording the
+// operation and, on success, that an LSAT was issued.
+func (s *instrumentedSecretStore) NewSecret(
+	id [sha256.Size]byte) ([sha256.Size]byte, error) {
+
+	secret, err := s.SecretStore.NewSecret(id)
+	metrics.ObserveSecretStoreOp("new_secret", err)
+	if err == nil {
+		metrics.IssuedLSAT()
+	}
+	return secret, err
+}
+
+// GetSecret looks up a secret via the wrapped store, recording the
+// operation's outcome.
+func (s *instrumentedSecretStore) GetSecret(
+	id [sha256.Size]byte) ([sha256.Size]byte, error) {
+
+	secret, err := s.SecretStore.GetSecret(id)
+	metrics.ObserveSecretStoreOp("get_secret", err)
+	return secret, err
+}
+
+// RevokeSecret removes a secret via the wrapped store, recording the
+// operation's outcome.
+func (s *instrumentedSecretStore) RevokeSecret(id [sha256.Size]byte) error {
+	err := s.SecretStore.RevokeSecret(id)
+	metrics.ObserveSecretStoreOp("revoke_secret", err)
+	return err
+}
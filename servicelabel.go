@@ -0,0 +1,28 @@
+package kirin
+
+import "context"
+
+// contextKey is an unexported type for this package's context keys, so they
+// can't collide with keys set by other packages.
+type contextKey int
+
+// serviceNameContextKey is the context key under which WithServiceName
+// stashes a service name, read back by ServiceNameFromContext for the
+// metrics request/duration labeling in start().
+const serviceNameContextKey contextKey = iota
+
+// WithServiceName returns a copy of ctx carrying name as the service matched
+// for the current request, so the metrics labeling in start() can label by
+// service name instead of the raw Host header. That labeling reads the
+// request context before the request reaches proxy.New's handler, so only a
+// name set before that point is seen.
+func WithServiceName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, serviceNameContextKey, name)
+}
+
+// ServiceNameFromContext returns the service name stashed by WithServiceName
+// and whether one was present.
+func ServiceNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(serviceNameContextKey).(string)
+	return name, ok
+}
@@ -0,0 +1,288 @@
+package kirin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultCertReloadInterval is how often we fall back to re-reading a
+// watched certificate or CA bundle from disk, in addition to the fsnotify
+// based watch, mirroring the default used by etcd's own
+// ReloadableServerConfig.
+const defaultCertReloadInterval = 5 * time.Minute
+
+// certReloader watches a certificate/key pair on disk and makes the most
+// recently loaded keypair available through GetCertificate and
+// GetClientCertificate, so a TLS server or client can pick up rotated
+// certificates without requiring a restart.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newCertReloader loads the given cert/key pair and starts watching them for
+// changes, reloading them in the background whenever fsnotify observes a
+// write to either file, or reloadInterval elapses, whichever happens first.
+func newCertReloader(certPath, keyPath string,
+	reloadInterval time.Duration) (*certReloader, error) {
+
+	c := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create fsnotify watcher: "+
+			"%v", err)
+	}
+	if err := watcher.Add(certPath); err != nil {
+		return nil, fmt.Errorf("unable to watch %v: %v", certPath, err)
+	}
+	if err := watcher.Add(keyPath); err != nil {
+		return nil, fmt.Errorf("unable to watch %v: %v", keyPath, err)
+	}
+
+	go c.watch(watcher, reloadInterval)
+
+	return c, nil
+}
+
+// watch blocks, reloading the certificate whenever fsnotify reports a change
+// to either file, or reloadInterval elapses, whichever happens first.
+func (c *certReloader) watch(watcher *fsnotify.Watcher,
+	reloadInterval time.Duration) {
+
+	defer watcher.Close()
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			c.reloadAndLog("fsnotify event")
+
+		case <-ticker.C:
+			c.reloadAndLog("periodic check")
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("TLS cert watcher error for %v: %v",
+				c.certPath, err)
+		}
+	}
+}
+
+// reloadAndLog re-reads the cert/key pair, logging the outcome.
+func (c *certReloader) reloadAndLog(trigger string) {
+	if err := c.reload(); err != nil {
+		log.Errorf("Unable to reload TLS cert %v: %v", c.certPath, err)
+		return
+	}
+	log.Infof("Reloaded TLS cert %v (%v)", c.certPath, trigger)
+}
+
+// reload re-reads and re-parses the certificate/key pair from disk and, if
+// successful, atomically swaps it in as the certificate returned by
+// GetCertificate/GetClientCertificate.
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate returns the most recently loaded certificate. It is
+// intended to be used as the tls.Config.GetCertificate callback of a TLS
+// server.
+func (c *certReloader) GetCertificate(
+	*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// GetClientCertificate returns the most recently loaded certificate. It is
+// intended to be used as the tls.Config.GetClientCertificate callback of a
+// TLS client, e.g. when authenticating to etcd with a client certificate.
+func (c *certReloader) GetClientCertificate(
+	*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// caPoolWatcher watches a PEM encoded CA bundle on disk and verifies peers in
+// VerifyPeerCertificate against the most recently loaded *x509.CertPool, so a
+// trust anchor rotation (e.g. on the
+// etcd side) can take effect without dropping existing connections.
+type caPoolWatcher struct {
+	caPath  string
+	dnsName string
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+}
+
+// newCAPoolWatcher loads the given PEM encoded CA bundle and starts watching
+// it for changes, reloading it in the background whenever fsnotify observes
+// a write, or reloadInterval elapses, whichever happens first. dnsName is
+// the hostname the peer's certificate must be valid for; it's checked on
+// every call to VerifyPeerCertificate so chaining to the CA pool alone
+// isn't sufficient to pass.
+func newCAPoolWatcher(caPath, dnsName string,
+	reloadInterval time.Duration) (*caPoolWatcher, error) {
+
+	w := &caPoolWatcher{caPath: caPath, dnsName: dnsName}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create fsnotify watcher: "+
+			"%v", err)
+	}
+	if err := watcher.Add(caPath); err != nil {
+		return nil, fmt.Errorf("unable to watch %v: %v", caPath, err)
+	}
+
+	go w.watch(watcher, reloadInterval)
+
+	return w, nil
+}
+
+// watch blocks, reloading the CA pool whenever fsnotify reports a change to
+// the bundle, or reloadInterval elapses, whichever happens first.
+func (w *caPoolWatcher) watch(watcher *fsnotify.Watcher,
+	reloadInterval time.Duration) {
+
+	defer watcher.Close()
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			w.reloadAndLog("fsnotify event")
+
+		case <-ticker.C:
+			w.reloadAndLog("periodic check")
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("CA pool watcher error for %v: %v",
+				w.caPath, err)
+		}
+	}
+}
+
+// reloadAndLog re-reads the CA bundle, logging the outcome.
+func (w *caPoolWatcher) reloadAndLog(trigger string) {
+	if err := w.reload(); err != nil {
+		log.Errorf("Unable to reload CA pool %v: %v", w.caPath, err)
+		return
+	}
+	log.Infof("Reloaded CA pool %v (%v)", w.caPath, trigger)
+}
+
+// reload re-reads and re-parses the CA bundle from disk and, if successful,
+// atomically swaps it in as the pool used by VerifyPeerCertificate.
+func (w *caPoolWatcher) reload() error {
+	pemBytes, err := ioutil.ReadFile(w.caPath)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return fmt.Errorf("no valid certificates found in %v", w.caPath)
+	}
+
+	w.mu.Lock()
+	w.pool = pool
+	w.mu.Unlock()
+
+	return nil
+}
+
+// VerifyPeerCertificate verifies the peer's certificate chain against the
+// most recently loaded CA pool and checks that it's valid for w.dnsName.
+// It's meant to be paired with tls.Config.InsecureSkipVerify so the
+// stdlib's built-in (static) chain verification is bypassed in favor of
+// this dynamic one; without the DNSName check below, that would mean any
+// leaf certificate chaining to the pool is accepted regardless of which
+// host presented it.
+func (w *caPoolWatcher) VerifyPeerCertificate(rawCerts [][]byte,
+	_ [][]*x509.Certificate) error {
+
+	w.mu.RLock()
+	pool := w.pool
+	w.mu.RUnlock()
+
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no peer certificate presented")
+	}
+
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse peer certificate: %v", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, raw := range rawCerts[1:] {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("unable to parse intermediate "+
+				"certificate: %v", err)
+		}
+		intermediates.AddCert(cert)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		DNSName:       w.dnsName,
+	})
+	return err
+}
@@ -0,0 +1,98 @@
+package kirin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// issueTestLeaf generates a CA and a leaf certificate signed by it, valid
+// for dnsName, returning the CA pool to verify against and the leaf's raw
+// DER bytes as handed to tls.Config.VerifyPeerCertificate.
+func issueTestLeaf(t *testing.T, dnsName string) (*x509.CertPool, []byte) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey,
+	)
+	if err != nil {
+		t.Fatalf("unable to create CA cert: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("unable to parse CA cert: %v", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(
+		rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey,
+	)
+	if err != nil {
+		t.Fatalf("unable to create leaf cert: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return pool, leafDER
+}
+
+func TestCAPoolWatcherVerifyPeerCertificate(t *testing.T) {
+	pool, leafDER := issueTestLeaf(t, "etcd.example.com")
+
+	t.Run("matching host is accepted", func(t *testing.T) {
+		w := &caPoolWatcher{pool: pool, dnsName: "etcd.example.com"}
+		if err := w.VerifyPeerCertificate(
+			[][]byte{leafDER}, nil,
+		); err != nil {
+			t.Fatalf("expected matching DNSName to verify, got: %v",
+				err)
+		}
+	})
+
+	t.Run("different host is rejected", func(t *testing.T) {
+		w := &caPoolWatcher{pool: pool, dnsName: "not-etcd.example.com"}
+		if err := w.VerifyPeerCertificate(
+			[][]byte{leafDER}, nil,
+		); err == nil {
+			t.Fatal("expected a certificate valid for a different " +
+				"host to be rejected")
+		}
+	})
+
+	t.Run("no certificate presented", func(t *testing.T) {
+		w := &caPoolWatcher{pool: pool, dnsName: "etcd.example.com"}
+		if err := w.VerifyPeerCertificate(nil, nil); err == nil {
+			t.Fatal("expected an error when no certificate is presented")
+		}
+	})
+}